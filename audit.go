@@ -0,0 +1,50 @@
+package authmiddleware
+
+import "time"
+
+// AuthEvent is a structured record of a single authentication attempt,
+// suitable for logging or forwarding to an AuditSink. It never carries
+// token material - only identifiers a strategy chose to surface, such as a
+// subject, kid, or issuer.
+type AuthEvent struct {
+	// Strategy is the name of the AuthStrategy that was tried, e.g.
+	// "local", "bearer", or "wso2". Empty for the final "deny" event
+	// logged when no strategy applied or succeeded. Events recorded by
+	// AuthenticateUser and PassGatekeeper use a step name instead:
+	// "session", "cas", "session-refresh", "session-issue", or
+	// "gatekeeper".
+	Strategy string
+
+	// Subject identifies who authenticated, when the attempt succeeded.
+	Subject string
+
+	// RequestID is the request's X-Request-Id header, if the caller set
+	// one, for correlating an event with the rest of a request's logs.
+	RequestID string
+
+	RemoteAddr string
+
+	// Outcome is one of "allow", "skip", "error", or "deny". "skip" means
+	// the strategy applied but didn't authenticate the request (e.g. an
+	// invalid bearer token); Authenticate logs "deny" once per request,
+	// after every strategy has been tried without success. AuthenticateUser
+	// also records a "deny" under "session" or "cas" when the user's
+	// groups fail the GEN_CONTROL_GROUPS check.
+	Outcome string
+
+	// Latency is how long the strategy took to evaluate the request.
+	Latency time.Duration
+
+	// Reason is the error message for an "error" outcome. Empty
+	// otherwise.
+	Reason string
+}
+
+// AuditSink receives every AuthEvent an Authenticator produces, so an
+// operator can forward successful and failed authentications to an
+// external system - syslog, Kafka, an HTTP webhook - for SOC-style
+// monitoring. Audit is called synchronously from Authenticate, so
+// implementations that talk to the network should do so asynchronously.
+type AuditSink interface {
+	Audit(event AuthEvent)
+}
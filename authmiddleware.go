@@ -1,32 +1,72 @@
 package authmiddleware
 
 import (
-	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/byuoitav/authmiddleware/bearertoken"
-	ad "github.com/byuoitav/authmiddleware/helpers/activedir"
-	"github.com/byuoitav/authmiddleware/wso2jwt"
+	"github.com/byuoitav/authmiddleware/session"
 	"github.com/jessemillar/jsonresp"
 	"github.com/shenshouer/cas"
 )
 
+// sessions is the optional session manager for AuthenticateUser. When nil
+// (the default), AuthenticateUser always re-runs the full CAS + Active
+// Directory check. Enable it with UseSessions.
+var sessions *session.Manager
+
+// UseSessions enables session cookies for AuthenticateUser, so a user who
+// has already passed CAS + the gatekeeper check skips that round trip on
+// subsequent requests until their session expires or is refreshed. keys is
+// the rotating encryption keyring; see session.NewManager for key rotation
+// and format requirements.
+func UseSessions(keys ...[]byte) error {
+	m, err := session.NewManager(keys...)
+	if err != nil {
+		return err
+	}
+
+	sessions = m
+	return nil
+}
+
+// Logout clears any session cookie on w, so the next request runs through
+// CAS and the gatekeeper check again.
+func Logout(w http.ResponseWriter) {
+	if sessions != nil {
+		sessions.Invalidate(w)
+	}
+}
+
+// UseLogger sets the structured logger the default Authenticator (used by
+// MachineChecks) logs each authentication attempt to. A nil logger resets
+// it to slog.Default().
+func UseLogger(logger *slog.Logger) {
+	defaultAuthenticator.WithLogger(logger)
+}
+
+// UseAuditSink registers sink with the default Authenticator (used by
+// MachineChecks) to receive every authentication outcome.
+func UseAuditSink(sink AuditSink) {
+	defaultAuthenticator.WithAuditSink(sink)
+}
+
 // Authenticate is the generalized middleware function
 // No CAS check for non-user access
 func Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		// If the request can pass the standard authentication then continue with the request.
-		passed, err := MachineChecks(request)
+		passed, principal, err := MachineChecksPrincipal(request)
 		if err != nil {
 			jsonresp.New(writer, http.StatusBadRequest, err.Error())
 			return
 		}
 
 		if passed {
+			request = request.WithContext(newContextWithPrincipal(request.Context(), principal))
 			next.ServeHTTP(writer, request)
 			return
 		}
@@ -35,7 +75,11 @@ func Authenticate(next http.Handler) http.Handler {
 	})
 }
 
-// AuthenticateUser is the middleware function for user access.
+// AuthenticateUser is the middleware function for user access. Once a user
+// passes CAS login (or resumes a session), their Active Directory groups
+// are checked against GEN_CONTROL_GROUPS. For per-route authorization
+// beyond that single env var, wrap the downstream handler with WithPolicy:
+// AuthenticateUser(WithPolicy(myPolicy, handler)).
 func AuthenticateUser(next http.Handler) http.Handler {
 	u, _ := url.Parse("https://cas.byu.edu/cas")
 	c := cas.NewClient(&cas.Options{
@@ -43,137 +87,184 @@ func AuthenticateUser(next http.Handler) http.Handler {
 	})
 
 	return c.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-Id")
+
 		// Run through MachineChecks. If not machine access, it is a user so check their rights.
-		passed, err := MachineChecks(r)
+		passed, principal, err := MachineChecksPrincipal(r)
 		if err != nil {
 			jsonresp.New(w, http.StatusBadRequest, err.Error())
 			return
 		}
 		// If it passed the MachineChecks, allow access.
 		if passed {
+			r = r.WithContext(newContextWithPrincipal(r.Context(), principal))
 			next.ServeHTTP(w, r)
+			return
 		}
-		// If not, run through user checks with AD
-		if !passed {
-			if !cas.IsAuthenticated(r) {
-				cas.RedirectToLogin(w, r)
-				return
-			}
-			// Compare User Active Directory groups against the General Control Groups.
-			control := strings.Split(os.Getenv("GEN_CONTROL_GROUPS"), ", ")
-			access := PassGatekeeper(cas.Username(r), control)
-			if access {
-				next.ServeHTTP(w, r)
-			}
-			if !access {
-				jsonresp.New(w, http.StatusBadRequest, "Not authorized")
-			}
-		}
-	})
-}
 
-// Boolean function for the standard automated checks that need to pass for any request.
-func MachineChecks(request *http.Request) (bool, error) {
-	passed, err := checkLocal()
-	if err != nil {
-		return passed, err
-	}
-	if passed {
-		return passed, nil
-	}
+		// Not machine access, so check for a session from a previous CAS
+		// login before falling back to CAS + AD.
+		if sessions != nil {
+			if sess, err := sessions.Read(r); err == nil {
+				start := time.Now()
+				principal := principalFromSession(sess)
+				allowed := groupsIntersect(principal.Groups, strings.Split(os.Getenv("GEN_CONTROL_GROUPS"), ", "))
 
-	passed, err = checkBearerToken(request)
-	if err != nil {
-		return passed, err
-	}
-	if passed {
-		return passed, nil
-	}
+				defaultAuthenticator.record(AuthEvent{
+					Strategy:   "session",
+					Subject:    principal.Subject,
+					RequestID:  requestID,
+					RemoteAddr: r.RemoteAddr,
+					Outcome:    allowOutcome(allowed),
+					Latency:    time.Since(start),
+				})
 
-	passed, err = checkWSO2(request)
-	if err != nil {
-		return passed, err
-	}
-	if passed {
-		return passed, nil
-	}
+				if !allowed {
+					jsonresp.New(w, http.StatusBadRequest, "Not authorized")
+					return
+				}
 
-	return passed, err
-}
-
-func checkLocal() (bool, error) {
-	log.Printf("Local check starting")
+				if err := sessions.Refresh(w, sess); err != nil {
+					defaultAuthenticator.record(AuthEvent{
+						Strategy:   "session-refresh",
+						Subject:    principal.Subject,
+						RequestID:  requestID,
+						RemoteAddr: r.RemoteAddr,
+						Outcome:    "error",
+						Reason:     err.Error(),
+					})
+				}
 
-	if len(os.Getenv("LOCAL_ENVIRONMENT")) > 0 {
-		log.Printf("Authorized via LOCAL_ENVIRONMENT")
-		return true, nil
-	}
+				r = r.WithContext(newContextWithPrincipal(r.Context(), principal))
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
 
-	log.Printf("Local check finished")
-	return false, nil
-}
+		// If not, run through user checks with AD
+		if !cas.IsAuthenticated(r) {
+			cas.RedirectToLogin(w, r)
+			return
+		}
 
-func checkBearerToken(request *http.Request) (bool, error) {
-	log.Printf("Bearer token check starting")
+		start := time.Now()
+		username := cas.Username(r)
+		ADGroups, err := cachedGroupsForUser(username)
+		if err != nil {
+			defaultAuthenticator.record(AuthEvent{
+				Strategy:   "cas",
+				Subject:    username,
+				RequestID:  requestID,
+				RemoteAddr: r.RemoteAddr,
+				Outcome:    "error",
+				Reason:     err.Error(),
+				Latency:    time.Since(start),
+			})
+			jsonresp.New(w, http.StatusBadRequest, "Not authorized")
+			return
+		}
 
-	token := request.Header.Get("Authorization") // Get the token if it exists
+		principal = Principal{Subject: username, Method: "cas", Groups: ADGroups}
+		allowed := groupsIntersect(ADGroups, strings.Split(os.Getenv("GEN_CONTROL_GROUPS"), ", "))
 
-	if len(token) > 0 { // Proceed if we found a token
-		parts := strings.Split(token, " ")
+		defaultAuthenticator.record(AuthEvent{
+			Strategy:   "cas",
+			Subject:    username,
+			RequestID:  requestID,
+			RemoteAddr: r.RemoteAddr,
+			Outcome:    allowOutcome(allowed),
+			Latency:    time.Since(start),
+		})
 
-		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
-			return false, errors.New("Bad Authorization header")
+		if !allowed {
+			jsonresp.New(w, http.StatusBadRequest, "Not authorized")
+			return
 		}
 
-		valid, err := bearertoken.CheckToken([]byte(parts[1])) // Validate the existing token
-		if err != nil {
-			return false, err
+		if sessions != nil {
+			if _, err := sessions.Issue(w, username, ADGroups); err != nil {
+				defaultAuthenticator.record(AuthEvent{
+					Strategy:   "session-issue",
+					Subject:    username,
+					RequestID:  requestID,
+					RemoteAddr: r.RemoteAddr,
+					Outcome:    "error",
+					Reason:     err.Error(),
+				})
+			}
 		}
 
-		if valid {
-			log.Println("Bearer token authorized")
-			return true, nil
-		}
-	}
+		r = r.WithContext(newContextWithPrincipal(r.Context(), principal))
 
-	log.Printf("Bearer token check finished")
-	return false, nil
+		next.ServeHTTP(w, r)
+	})
 }
 
-func checkWSO2(request *http.Request) (bool, error) {
-	log.Printf("WSO2 check starting")
-
-	token := request.Header.Get("X-jwt-assertion") // Get the token if it exists
-
-	if len(token) > 0 { // Proceed if we found a token
-		valid, err := wso2jwt.Validate(token) // Validate the existing token
-		if err != nil {
-			log.Printf("Invalid WSO2 information")
-			return false, err
-		}
+// allowOutcome maps a group-check decision to the AuthEvent.Outcome vocabulary
+// used by Authenticator.record: "allow" or "deny".
+func allowOutcome(allowed bool) string {
+	if allowed {
+		return "allow"
+	}
+	return "deny"
+}
 
-		if valid {
-			log.Printf("WSO2 validated successfully")
-			return true, nil
-		}
+// principalFromSession builds the Principal for a request authenticated by
+// a previously-issued session cookie, rather than a fresh CAS login.
+func principalFromSession(sess session.Session) Principal {
+	return Principal{
+		Subject:   sess.Username,
+		Method:    "session",
+		IssuedAt:  sess.IssuedAt,
+		ExpiresAt: sess.ExpiresAt,
+		Groups:    sess.Groups,
 	}
+}
+
+// MachineChecks runs the standard automated checks that need to pass for
+// any request. It runs the request through the default Authenticator's
+// strategies (local environment, bearer token, then WSO2 JWT). Use a
+// custom Authenticator directly to register, reorder, or disable
+// strategies.
+//
+// Deprecated: the Principal established by the successful strategy is
+// often needed by callers that want to attach it to the request context;
+// use MachineChecksPrincipal for that. MachineChecks is kept, unchanged,
+// for existing callers.
+func MachineChecks(request *http.Request) (bool, error) {
+	passed, _, err := MachineChecksPrincipal(request)
+	return passed, err
+}
 
-	log.Printf("WSO2 check finished")
-	return false, nil
+// MachineChecksPrincipal is MachineChecks, but also returns the Principal
+// established by whichever strategy succeeded.
+func MachineChecksPrincipal(request *http.Request) (bool, Principal, error) {
+	return defaultAuthenticator.Authenticate(request.Context(), request)
 }
 
 // PassGatekeeper is the check for a user's Active Directory groups against some control groups
 // to allow access based on the needs for the request.
 func PassGatekeeper(user string, control []string) bool {
-	ADGroups, err := ad.GetGroupsForUser(user)
+	ADGroups, err := cachedGroupsForUser(user)
 	if err != nil {
-		log.Printf("Error getting groups for the user: %v", err.Error())
+		defaultAuthenticator.record(AuthEvent{
+			Strategy: "gatekeeper",
+			Subject:  user,
+			Outcome:  "error",
+			Reason:   err.Error(),
+		})
 		return false
 	}
 
+	return groupsIntersect(ADGroups, control)
+}
+
+// groupsIntersect reports whether groups and control share at least one
+// entry.
+func groupsIntersect(groups, control []string) bool {
 	for i := range control {
-		for j := range ADGroups {
-			if control[i] == ADGroups[j] {
+		for j := range groups {
+			if control[i] == groups[j] {
 				return true
 			}
 		}
@@ -0,0 +1,80 @@
+package authmiddleware
+
+import (
+	"sync"
+	"time"
+
+	ad "github.com/byuoitav/authmiddleware/helpers/activedir"
+)
+
+// defaultGroupCacheTTL is how long a user's Active Directory group
+// membership is cached before AuthenticateUser/PassGatekeeper re-query the
+// directory for it.
+const defaultGroupCacheTTL = 5 * time.Minute
+
+// groups is the package-level cache backing cachedGroupsForUser.
+var groups = newGroupCache(defaultGroupCacheTTL)
+
+// SetGroupCacheTTL overrides how long an Active Directory group lookup is
+// cached before it's repeated. A TTL of zero disables caching, so every
+// call hits the directory.
+func SetGroupCacheTTL(ttl time.Duration) {
+	groups.setTTL(ttl)
+}
+
+type groupCacheEntry struct {
+	groups    []string
+	expiresAt time.Time
+}
+
+// groupCache caches ad.GetGroupsForUser results by username for a
+// configurable TTL, so a policy that runs on every request doesn't hammer
+// the directory for users making repeated calls.
+type groupCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]groupCacheEntry
+}
+
+func newGroupCache(ttl time.Duration) *groupCache {
+	return &groupCache{
+		ttl:     ttl,
+		entries: make(map[string]groupCacheEntry),
+	}
+}
+
+func (c *groupCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+func (c *groupCache) get(username string) ([]string, error) {
+	c.mu.Lock()
+	ttl := c.ttl
+	entry, ok := c.entries[username]
+	c.mu.Unlock()
+
+	if ttl > 0 && ok && time.Now().Before(entry.expiresAt) {
+		return entry.groups, nil
+	}
+
+	fetched, err := ad.GetGroupsForUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl > 0 {
+		c.mu.Lock()
+		c.entries[username] = groupCacheEntry{groups: fetched, expiresAt: time.Now().Add(ttl)}
+		c.mu.Unlock()
+	}
+
+	return fetched, nil
+}
+
+// cachedGroupsForUser returns username's Active Directory groups, from the
+// cache if a fresh entry exists.
+func cachedGroupsForUser(username string) ([]string, error) {
+	return groups.get(username)
+}
@@ -0,0 +1,37 @@
+package authmiddleware
+
+import (
+	"errors"
+
+	"github.com/byuoitav/authmiddleware/oidcjwt"
+)
+
+// NewOIDCStrategy builds a JWT strategy backed by a remote OIDC provider's
+// JWKS, reading the token from the Authorization header's Bearer scheme.
+// It lets downstream services accept tokens from any OIDC IdP (Azure AD,
+// Okta, Keycloak, ...) alongside or instead of WSO2. jwtStrategy.Applies
+// only dispatches JWT-shaped Authorization tokens here, so it can be
+// registered alongside NewBearerStrategy without either calling the
+// other's backend.
+func NewOIDCStrategy(name string, validator *oidcjwt.Validator) AuthStrategy {
+	return jwtStrategy{
+		name:   name,
+		header: "Authorization",
+		validate: func(token string) (bool, error) {
+			raw, ok := splitBearerToken(token)
+			if !ok {
+				return false, nil
+			}
+
+			return validator.Validate(raw)
+		},
+		claims: func(token string) (map[string]interface{}, error) {
+			raw, ok := splitBearerToken(token)
+			if !ok {
+				return nil, errors.New("oidcjwt: missing Bearer token")
+			}
+
+			return validator.Claims(raw)
+		},
+	}
+}
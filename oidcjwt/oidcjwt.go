@@ -0,0 +1,282 @@
+// Package oidcjwt validates JWTs issued by an OIDC identity provider. Unlike
+// wso2jwt's single hardcoded certificate, it discovers the provider's
+// signing keys from its JWKS endpoint (found via
+// .well-known/openid-configuration) and keeps them fresh as they rotate.
+package oidcjwt
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Config configures a Validator for a single OIDC issuer.
+type Config struct {
+	// Issuer is the OIDC issuer URL, e.g.
+	// "https://login.microsoftonline.com/{tenant}/v2.0". Its
+	// .well-known/openid-configuration document is used to discover the
+	// JWKS endpoint, and every token's "iss" claim must match it exactly.
+	Issuer string
+
+	// AllowedAudiences restricts accepted tokens to those whose "aud"
+	// claim matches one of these values. If empty, the audience is not
+	// checked.
+	AllowedAudiences []string
+
+	// KeyRefreshRateLimit is the minimum time between JWKS refetches
+	// triggered by a signing-key cache miss. Defaults to 5 minutes.
+	KeyRefreshRateLimit time.Duration
+
+	// HTTPClient is used for discovery and JWKS requests. Defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Validator validates JWTs against a single OIDC issuer's JWKS, caching
+// signing keys by "kid" and refreshing the set on a cache miss, no more
+// often than Config.KeyRefreshRateLimit.
+type Validator struct {
+	config Config
+	client *http.Client
+
+	mu          sync.RWMutex
+	keys        map[string]*rsa.PublicKey
+	jwksURI     string
+	lastRefresh time.Time
+}
+
+// NewValidator builds a Validator for the given Config. The issuer's JWKS
+// endpoint is discovered lazily, on the first call to Validate.
+func NewValidator(config Config) *Validator {
+	client := config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if config.KeyRefreshRateLimit == 0 {
+		config.KeyRefreshRateLimit = 5 * time.Minute
+	}
+
+	return &Validator{
+		config: config,
+		client: client,
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+}
+
+// Validate parses and verifies a raw JWT: its signature against the
+// issuer's JWKS, and its iss, aud, exp, nbf, and iat claims.
+func (v *Validator) Validate(token string) (bool, error) {
+	if _, err := v.Claims(token); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Claims parses and verifies a raw JWT exactly as Validate does, but
+// returns its claims instead of a bare bool. Callers that need to know who
+// authenticated - not just that they did - use this to populate a
+// Principal with the subject, issued-at, and expiry claims.
+func (v *Validator) Claims(token string) (map[string]interface{}, error) {
+	parsed, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("oidcjwt: unexpected signing method %v", t.Header["alg"])
+		}
+
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("oidcjwt: token is missing a kid")
+		}
+
+		return v.key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok || !parsed.Valid {
+		return nil, errors.New("oidcjwt: invalid token")
+	}
+
+	if err := v.verifyClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *Validator) verifyClaims(claims jwt.MapClaims) error {
+	if !claims.VerifyIssuer(v.config.Issuer, true) {
+		return fmt.Errorf("oidcjwt: unexpected issuer %q", claims["iss"])
+	}
+
+	if len(v.config.AllowedAudiences) == 0 {
+		return nil
+	}
+
+	for _, aud := range v.config.AllowedAudiences {
+		if claims.VerifyAudience(aud, true) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("oidcjwt: audience %v not allowed", claims["aud"])
+}
+
+// key returns the cached public key for kid, refreshing the JWKS (subject
+// to the configured rate limit) on a cache miss.
+func (v *Validator) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	v.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if err := v.refreshKeys(); err != nil {
+		return nil, err
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	key, ok = v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidcjwt: unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+func (v *Validator) refreshKeys() error {
+	v.mu.Lock()
+	if time.Since(v.lastRefresh) < v.config.KeyRefreshRateLimit {
+		v.mu.Unlock()
+		return nil
+	}
+	v.lastRefresh = time.Now()
+	v.mu.Unlock()
+
+	jwksURI, err := v.discoverJWKSURI()
+	if err != nil {
+		return err
+	}
+
+	keys, err := v.fetchKeys(jwksURI)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.mu.Unlock()
+	return nil
+}
+
+type openIDConfiguration struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+func (v *Validator) discoverJWKSURI() (string, error) {
+	v.mu.RLock()
+	jwksURI := v.jwksURI
+	v.mu.RUnlock()
+	if jwksURI != "" {
+		return jwksURI, nil
+	}
+
+	resp, err := v.client.Get(v.config.Issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidcjwt: discovery returned %s", resp.Status)
+	}
+
+	var discovery openIDConfiguration
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return "", err
+	}
+	if discovery.JWKSURI == "" {
+		return "", errors.New("oidcjwt: discovery document is missing jwks_uri")
+	}
+
+	v.mu.Lock()
+	v.jwksURI = discovery.JWKSURI
+	v.mu.Unlock()
+
+	return discovery.JWKSURI, nil
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (v *Validator) fetchKeys(jwksURI string) (map[string]*rsa.PublicKey, error) {
+	resp, err := v.client.Get(jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidcjwt: fetching JWKS returned %s", resp.Status)
+	}
+
+	var jwks jsonWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || k.Kid == "" {
+			continue
+		}
+
+		key, err := k.rsaPublicKey()
+		if err != nil {
+			return nil, err
+		}
+
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("oidcjwt: decoding modulus for key %q: %v", k.Kid, err)
+	}
+
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("oidcjwt: decoding exponent for key %q: %v", k.Kid, err)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
@@ -0,0 +1,182 @@
+package oidcjwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// newTestIssuer starts an httptest server serving OIDC discovery and a
+// JWKS for key, under kid, and returns it along with a matching Config.
+// Callers must Close() the returned server.
+func newTestIssuer(t *testing.T, kid string, key *rsa.PrivateKey) (*httptest.Server, Config) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	var issuer string
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   issuer,
+			"jwks_uri": issuer + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": kid,
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		})
+	})
+
+	server := httptest.NewServer(mux)
+	issuer = server.URL
+
+	return server, Config{
+		Issuer:           issuer,
+		AllowedAudiences: []string{"test-audience"},
+	}
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+
+	return signed
+}
+
+func baseClaims(issuer string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss": issuer,
+		"aud": "test-audience",
+		"sub": "user-1",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestValidatorValidateAndClaims(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	server, config := newTestIssuer(t, "kid-1", key)
+	defer server.Close()
+
+	validator := NewValidator(config)
+	token := signToken(t, key, "kid-1", baseClaims(config.Issuer))
+
+	valid, err := validator.Validate(token)
+	if err != nil || !valid {
+		t.Fatalf("Validate(valid token) = (%v, %v), want (true, nil)", valid, err)
+	}
+
+	claims, err := validator.Claims(token)
+	if err != nil {
+		t.Fatalf("Claims(valid token): %v", err)
+	}
+	if claims["sub"] != "user-1" {
+		t.Errorf("claims[sub] = %v, want user-1", claims["sub"])
+	}
+}
+
+func TestValidatorRejectsExpiredToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	server, config := newTestIssuer(t, "kid-1", key)
+	defer server.Close()
+
+	validator := NewValidator(config)
+
+	claims := baseClaims(config.Issuer)
+	claims["iat"] = time.Now().Add(-2 * time.Hour).Unix()
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	token := signToken(t, key, "kid-1", claims)
+
+	if valid, err := validator.Validate(token); err == nil || valid {
+		t.Fatalf("Validate(expired token) = (%v, %v), want (false, non-nil error)", valid, err)
+	}
+}
+
+func TestValidatorRejectsWrongIssuer(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	server, config := newTestIssuer(t, "kid-1", key)
+	defer server.Close()
+
+	validator := NewValidator(config)
+
+	claims := baseClaims("https://not-the-configured-issuer.example")
+	token := signToken(t, key, "kid-1", claims)
+
+	if valid, err := validator.Validate(token); err == nil || valid {
+		t.Fatalf("Validate(wrong issuer) = (%v, %v), want (false, non-nil error)", valid, err)
+	}
+}
+
+func TestValidatorRejectsWrongAudience(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	server, config := newTestIssuer(t, "kid-1", key)
+	defer server.Close()
+
+	validator := NewValidator(config)
+
+	claims := baseClaims(config.Issuer)
+	claims["aud"] = "some-other-audience"
+	token := signToken(t, key, "kid-1", claims)
+
+	if valid, err := validator.Validate(token); err == nil || valid {
+		t.Fatalf("Validate(wrong audience) = (%v, %v), want (false, non-nil error)", valid, err)
+	}
+}
+
+func TestValidatorRejectsUnknownKid(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	server, config := newTestIssuer(t, "kid-1", key)
+	defer server.Close()
+
+	validator := NewValidator(config)
+
+	token := signToken(t, key, "kid-does-not-exist", baseClaims(config.Issuer))
+
+	if valid, err := validator.Validate(token); err == nil || valid {
+		t.Fatalf("Validate(unknown kid) = (%v, %v), want (false, non-nil error)", valid, err)
+	}
+}
@@ -0,0 +1,287 @@
+// Package session issues and validates encrypted cookies that carry an
+// authenticated user's identity and group memberships, so callers like
+// AuthenticateUser can skip re-running CAS and an Active Directory lookup
+// on every request.
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Session is the identity and authorization state carried in an encrypted
+// cookie after a successful CAS login.
+type Session struct {
+	Username  string    `json:"username"`
+	Groups    []string  `json:"groups"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the session has passed its expiry.
+func (s Session) Expired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
+
+func (s Session) nearExpiry(window time.Duration) bool {
+	return time.Now().Add(window).After(s.ExpiresAt)
+}
+
+const (
+	// CookieName is the base name for the session cookie. Payloads larger
+	// than maxCookieSize are split across CookieName, CookieName+"-1",
+	// CookieName+"-2", etc - the way OIDC/oauth2_proxy deployments chunk
+	// large group claims across multiple Set-Cookie headers.
+	CookieName = "authmw_session"
+
+	maxCookieSize = 4000
+	maxChunks     = 10
+
+	defaultTTL           = 8 * time.Hour
+	defaultRefreshWindow = 1 * time.Hour
+)
+
+// Manager encrypts and decrypts Session cookies with AES-GCM, keyed by a
+// rotating set of server-side secrets.
+type Manager struct {
+	keys          [][]byte
+	ttl           time.Duration
+	refreshWindow time.Duration
+}
+
+// NewManager builds a Manager. keys is the active keyring, in priority
+// order: keys[0] encrypts new sessions, and every key is tried when
+// decrypting, so a secret can be rotated by prepending the new one and
+// dropping the old one once it's no longer in use. Each key must be 16,
+// 24, or 32 bytes long (AES-128/192/256).
+func NewManager(keys ...[]byte) (*Manager, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("session: at least one key is required")
+	}
+
+	for _, key := range keys {
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("session: invalid key: %v", err)
+		}
+	}
+
+	return &Manager{
+		keys:          keys,
+		ttl:           defaultTTL,
+		refreshWindow: defaultRefreshWindow,
+	}, nil
+}
+
+// WithTTL overrides the default session lifetime.
+func (m *Manager) WithTTL(ttl time.Duration) *Manager {
+	m.ttl = ttl
+	return m
+}
+
+// WithRefreshWindow overrides how close to expiry Refresh reissues a
+// session.
+func (m *Manager) WithRefreshWindow(window time.Duration) *Manager {
+	m.refreshWindow = window
+	return m
+}
+
+// Issue encrypts a new Session for username/groups and writes it to w as
+// one or more Set-Cookie headers.
+func (m *Manager) Issue(w http.ResponseWriter, username string, groups []string) (Session, error) {
+	now := time.Now()
+	sess := Session{
+		Username:  username,
+		Groups:    groups,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(m.ttl),
+	}
+
+	return sess, m.write(w, sess)
+}
+
+// Read decrypts and validates the Session carried by r's cookies. It
+// returns an error if no session cookie is present, it fails to decrypt
+// with any known key, or it has expired.
+func (m *Manager) Read(r *http.Request) (Session, error) {
+	payload, err := m.reassemble(r)
+	if err != nil {
+		return Session{}, err
+	}
+
+	plaintext, err := m.decrypt(payload)
+	if err != nil {
+		return Session{}, err
+	}
+
+	var sess Session
+	if err := json.Unmarshal(plaintext, &sess); err != nil {
+		return Session{}, fmt.Errorf("session: malformed payload: %v", err)
+	}
+
+	if sess.Expired() {
+		return Session{}, errors.New("session: expired")
+	}
+
+	return sess, nil
+}
+
+// Refresh silently reissues sess on w if it is within its refresh window,
+// extending its expiry. It is a no-op otherwise.
+func (m *Manager) Refresh(w http.ResponseWriter, sess Session) error {
+	if !sess.nearExpiry(m.refreshWindow) {
+		return nil
+	}
+
+	_, err := m.Issue(w, sess.Username, sess.Groups)
+	return err
+}
+
+// Invalidate clears every chunk of the session cookie on w, logging the
+// user out.
+func (m *Manager) Invalidate(w http.ResponseWriter) {
+	for i := 0; i < maxChunks; i++ {
+		http.SetCookie(w, &http.Cookie{
+			Name:     chunkName(i),
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   true,
+		})
+	}
+}
+
+func chunkName(i int) string {
+	if i == 0 {
+		return CookieName
+	}
+	return fmt.Sprintf("%s-%d", CookieName, i)
+}
+
+func (m *Manager) write(w http.ResponseWriter, sess Session) error {
+	plaintext, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+
+	payload, err := m.encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+
+	chunks := 0
+	for len(payload) > 0 {
+		if chunks >= maxChunks {
+			return fmt.Errorf("session: payload requires more than %d cookie chunks", maxChunks)
+		}
+
+		end := maxCookieSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     chunkName(chunks),
+			Value:    payload[:end],
+			Path:     "/",
+			Expires:  sess.ExpiresAt,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		payload = payload[end:]
+		chunks++
+	}
+
+	// Clear any higher-index chunks left over from a previous, larger
+	// session, so reassemble doesn't concatenate their stale ciphertext
+	// onto this payload on the next request.
+	for i := chunks; i < maxChunks; i++ {
+		http.SetCookie(w, &http.Cookie{
+			Name:     chunkName(i),
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   true,
+		})
+	}
+
+	return nil
+}
+
+func (m *Manager) reassemble(r *http.Request) (string, error) {
+	var payload string
+
+	for i := 0; i < maxChunks; i++ {
+		c, err := r.Cookie(chunkName(i))
+		if err != nil {
+			if i == 0 {
+				return "", errors.New("session: no session cookie")
+			}
+			break
+		}
+		payload += c.Value
+	}
+
+	return payload, nil
+}
+
+func (m *Manager) encrypt(plaintext []byte) (string, error) {
+	block, err := aes.NewCipher(m.keys[0])
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+	return base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+func (m *Manager) decrypt(payload string) ([]byte, error) {
+	ciphertext, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("session: malformed cookie: %v", err)
+	}
+
+	for _, key := range m.keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			continue
+		}
+
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			continue
+		}
+
+		if len(ciphertext) < gcm.NonceSize() {
+			continue
+		}
+
+		nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+		if plaintext, err := gcm.Open(nil, nonce, sealed, nil); err == nil {
+			return plaintext, nil
+		}
+	}
+
+	return nil, errors.New("session: could not decrypt cookie with any known key")
+}
@@ -0,0 +1,235 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func key(b byte) []byte {
+	k := make([]byte, 32)
+	for i := range k {
+		k[i] = b
+	}
+	return k
+}
+
+// requestWithCookies builds a request carrying every cookie set on rec.
+func requestWithCookies(rec *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestManagerIssueAndReadRoundTrip(t *testing.T) {
+	m, err := NewManager(key(1))
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	issued, err := m.Issue(rec, "someone", []string{"admins", "ops"})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	got, err := m.Read(requestWithCookies(rec))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	if got.Username != "someone" || len(got.Groups) != 2 || got.Groups[0] != "admins" || got.Groups[1] != "ops" {
+		t.Errorf("Read() = %+v, want username=someone groups=[admins ops]", got)
+	}
+	if !got.ExpiresAt.Equal(issued.ExpiresAt) {
+		t.Errorf("ExpiresAt = %v, want %v", got.ExpiresAt, issued.ExpiresAt)
+	}
+}
+
+func TestManagerChunksOversizedPayload(t *testing.T) {
+	m, err := NewManager(key(1))
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	// A group list well past maxCookieSize once JSON-encoded and encrypted,
+	// so Issue must split it across multiple Set-Cookie headers.
+	groups := make([]string, 500)
+	for i := range groups {
+		groups[i] = strings.Repeat("g", 20) + string(rune('a'+i%26))
+	}
+
+	rec := httptest.NewRecorder()
+	if _, err := m.Issue(rec, "someone", groups); err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) < 2 {
+		t.Fatalf("got %d cookies, want more than 1 for an oversized payload", len(cookies))
+	}
+
+	got, err := m.Read(requestWithCookies(rec))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	if len(got.Groups) != len(groups) {
+		t.Fatalf("Read() groups len = %d, want %d", len(got.Groups), len(groups))
+	}
+	for i := range groups {
+		if got.Groups[i] != groups[i] {
+			t.Fatalf("Read() groups[%d] = %q, want %q", i, got.Groups[i], groups[i])
+		}
+	}
+}
+
+// applyCookies updates jar (keyed by cookie name) with the Set-Cookie
+// headers from rec, the way a browser would: a cookie with MaxAge < 0 is
+// removed, every other cookie is upserted.
+func applyCookies(jar map[string]*http.Cookie, rec *httptest.ResponseRecorder) {
+	for _, c := range rec.Result().Cookies() {
+		if c.MaxAge < 0 {
+			delete(jar, c.Name)
+			continue
+		}
+		jar[c.Name] = c
+	}
+}
+
+func requestFromJar(jar map[string]*http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range jar {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestManagerIssueClearsStaleChunks(t *testing.T) {
+	m, err := NewManager(key(1))
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	// Issue a large, multi-chunk session, then simulate the browser holding
+	// exactly the cookies that produced.
+	groups := make([]string, 500)
+	for i := range groups {
+		groups[i] = strings.Repeat("g", 20) + string(rune('a'+i%26))
+	}
+
+	rec := httptest.NewRecorder()
+	if _, err := m.Issue(rec, "someone", groups); err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	jar := map[string]*http.Cookie{}
+	applyCookies(jar, rec)
+	if len(jar) < 2 {
+		t.Fatalf("got %d cookies, want more than 1 for an oversized payload", len(jar))
+	}
+
+	// Reissue with a much smaller payload that only needs a single chunk.
+	// write must clear the chunks the first, larger session used that the
+	// new one doesn't, or they'll corrupt the next reassemble.
+	rec2 := httptest.NewRecorder()
+	if _, err := m.Issue(rec2, "someone", []string{"admins"}); err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	applyCookies(jar, rec2)
+
+	got, err := m.Read(requestFromJar(jar))
+	if err != nil {
+		t.Fatalf("Read after shrinking session: %v", err)
+	}
+	if len(got.Groups) != 1 || got.Groups[0] != "admins" {
+		t.Errorf("Read() groups = %v, want [admins]", got.Groups)
+	}
+}
+
+func TestManagerKeyRotation(t *testing.T) {
+	oldManager, err := NewManager(key(1))
+	if err != nil {
+		t.Fatalf("NewManager(old): %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	if _, err := oldManager.Issue(rec, "someone", []string{"admins"}); err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	// A rotated Manager with the new key first and the old key still
+	// listed must be able to decrypt a cookie issued under the old key.
+	rotated, err := NewManager(key(2), key(1))
+	if err != nil {
+		t.Fatalf("NewManager(rotated): %v", err)
+	}
+
+	got, err := rotated.Read(requestWithCookies(rec))
+	if err != nil {
+		t.Fatalf("Read of old-key cookie with rotated Manager: %v", err)
+	}
+	if got.Username != "someone" {
+		t.Errorf("Username = %q, want someone", got.Username)
+	}
+
+	// A fresh Issue from the rotated Manager must use the new key, so a
+	// Manager that only knows the old key can no longer decrypt it.
+	rec2 := httptest.NewRecorder()
+	if _, err := rotated.Issue(rec2, "someone-else", []string{"ops"}); err != nil {
+		t.Fatalf("Issue(rotated): %v", err)
+	}
+
+	if _, err := oldManager.Read(requestWithCookies(rec2)); err == nil {
+		t.Error("old-key-only Manager decrypted a cookie issued with the new key, want error")
+	}
+}
+
+func TestManagerReadRejectsExpiredSession(t *testing.T) {
+	m, err := NewManager(key(1))
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	m.WithTTL(-time.Minute)
+
+	rec := httptest.NewRecorder()
+	if _, err := m.Issue(rec, "someone", nil); err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	if _, err := m.Read(requestWithCookies(rec)); err == nil {
+		t.Error("Read of an already-expired session succeeded, want error")
+	}
+}
+
+func TestManagerInvalidateClearsCookie(t *testing.T) {
+	m, err := NewManager(key(1))
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	logout := httptest.NewRecorder()
+	m.Invalidate(logout)
+
+	cookies := logout.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("Invalidate set no cookies")
+	}
+
+	for _, c := range cookies {
+		if c.Value != "" || c.MaxAge >= 0 {
+			t.Errorf("Invalidate cookie %q = {Value: %q, MaxAge: %d}, want empty value and MaxAge < 0", c.Name, c.Value, c.MaxAge)
+		}
+	}
+
+	// A request carrying only the cleared cookie (as a browser would send
+	// after honoring MaxAge < 0, i.e. no cookie at all) must fail to read.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := m.Read(r); err == nil {
+		t.Error("Read succeeded with no session cookie present, want error")
+	}
+}
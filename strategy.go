@@ -0,0 +1,361 @@
+package authmiddleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/byuoitav/authmiddleware/bearertoken"
+	"github.com/byuoitav/authmiddleware/wso2jwt"
+)
+
+// ErrNotAuthenticated is returned by an AuthStrategy to indicate that the
+// request simply didn't satisfy that strategy (e.g. a bearer token that
+// doesn't check out). It is not treated as a hard failure - the
+// Authenticator moves on and tries the next applicable strategy. Any other
+// error is treated as terminal and is returned to the caller.
+var ErrNotAuthenticated = errors.New("authmiddleware: not authenticated")
+
+// Principal represents the identity established by a successful AuthStrategy
+// or CAS + AD login. It is attached to the request context so downstream
+// handlers can tell who authenticated and how, instead of just that they
+// did; see FromContext.
+type Principal struct {
+	// Subject identifies who authenticated, e.g. a username or "bearer"
+	// for a strategy with no finer-grained identity.
+	Subject string
+
+	// Method is the name of the strategy (or "session"/"cas") that
+	// authenticated the request.
+	Method string
+
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+
+	// Groups is the subject's Active Directory or claim-based group
+	// memberships, when known.
+	Groups []string
+
+	// Claims holds the raw claims of a validated JWT, when the strategy
+	// is JWT-backed. It is nil for non-JWT strategies.
+	Claims map[string]interface{}
+}
+
+// principalContextKey is an unexported type so FromContext/context.WithValue
+// keys can't collide with values set by other packages.
+type principalContextKey struct{}
+
+// FromContext returns the Principal that Authenticate or AuthenticateUser
+// attached to ctx, if any.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(Principal)
+	return p, ok
+}
+
+// newContextWithPrincipal returns a copy of ctx carrying principal, for
+// FromContext to later retrieve.
+func newContextWithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// AuthStrategy is a single method of authenticating an incoming request.
+// Strategies are tried in the order they are registered on an Authenticator,
+// skipping any whose Applies returns false, until one succeeds or returns a
+// terminal error.
+type AuthStrategy interface {
+	// Name identifies the strategy for logging and configuration purposes.
+	Name() string
+
+	// Applies reports whether this strategy should be attempted for the
+	// given request, e.g. because the header or token type it expects is
+	// present.
+	Applies(request *http.Request) bool
+
+	// Authenticate validates the request and returns the resulting
+	// Principal. Returning ErrNotAuthenticated lets the Authenticator fall
+	// through to the next strategy; any other error aborts the chain.
+	Authenticate(ctx context.Context, request *http.Request) (Principal, error)
+}
+
+// Authenticator runs a request through an ordered list of AuthStrategy
+// implementations. The zero value has no strategies registered and logs to
+// slog.Default() with no audit sink.
+type Authenticator struct {
+	strategies []AuthStrategy
+	logger     *slog.Logger
+	auditSink  AuditSink
+}
+
+// WithLogger sets the structured logger each authentication attempt is
+// logged to. A nil logger (the default) logs to slog.Default().
+func (a *Authenticator) WithLogger(logger *slog.Logger) *Authenticator {
+	a.logger = logger
+	return a
+}
+
+// WithAuditSink registers sink to additionally receive every authentication
+// outcome, alongside the Authenticator's own structured logging.
+func (a *Authenticator) WithAuditSink(sink AuditSink) *Authenticator {
+	a.auditSink = sink
+	return a
+}
+
+// Use registers one or more strategies, appending them to the order they
+// will be tried in. Building a fresh Authenticator and calling Use with a
+// reordered or trimmed list of strategies is how callers customize or
+// disable the built-in checks, or add their own (an OIDC strategy, a
+// reva-style opaque-token strategy, etc).
+func (a *Authenticator) Use(strategies ...AuthStrategy) {
+	a.strategies = append(a.strategies, strategies...)
+}
+
+// Authenticate tries each registered strategy in order, skipping those that
+// don't apply to the request, and returns the Principal from the first one
+// that succeeds. The first strategy to return an error other than
+// ErrNotAuthenticated stops the chain, and that error is returned. If no
+// strategy applies or succeeds, it reports not passed. Every attempt is
+// logged as a structured AuthEvent and, if one is registered, forwarded to
+// the AuditSink.
+func (a *Authenticator) Authenticate(ctx context.Context, request *http.Request) (bool, Principal, error) {
+	requestID := request.Header.Get("X-Request-Id")
+
+	for _, strategy := range a.strategies {
+		if !strategy.Applies(request) {
+			continue
+		}
+
+		start := time.Now()
+		principal, err := strategy.Authenticate(ctx, request)
+
+		event := AuthEvent{
+			Strategy:   strategy.Name(),
+			RequestID:  requestID,
+			RemoteAddr: request.RemoteAddr,
+			Latency:    time.Since(start),
+		}
+
+		if err != nil {
+			if errors.Is(err, ErrNotAuthenticated) {
+				event.Outcome = "skip"
+				a.record(event)
+				continue
+			}
+
+			event.Outcome = "error"
+			event.Reason = err.Error()
+			a.record(event)
+
+			return false, Principal{}, err
+		}
+
+		event.Outcome = "allow"
+		event.Subject = principal.Subject
+		a.record(event)
+
+		return true, principal, nil
+	}
+
+	a.record(AuthEvent{
+		RequestID:  requestID,
+		RemoteAddr: request.RemoteAddr,
+		Outcome:    "deny",
+	})
+
+	return false, Principal{}, nil
+}
+
+// record logs event to a.logger (or slog.Default(), if none is set) and
+// forwards it to a.auditSink, if one is registered. Token material is
+// never part of an AuthEvent - only the subject, kid, or issuer a strategy
+// chose to report.
+func (a *Authenticator) record(event AuthEvent) {
+	logger := a.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	logger.Info("auth attempt",
+		"strategy", event.Strategy,
+		"subject", event.Subject,
+		"request_id", event.RequestID,
+		"remote_addr", event.RemoteAddr,
+		"outcome", event.Outcome,
+		"latency", event.Latency,
+		"reason", event.Reason,
+	)
+
+	if a.auditSink != nil {
+		a.auditSink.Audit(event)
+	}
+}
+
+// defaultAuthenticator is the built-in ordering used by MachineChecks: a
+// local-environment bypass, then opaque bearer tokens, then WSO2 JWTs.
+var defaultAuthenticator = func() *Authenticator {
+	a := &Authenticator{}
+	a.Use(NewLocalStrategy(), NewBearerStrategy(), NewWSO2Strategy())
+	return a
+}()
+
+// localStrategy authorizes every request when running in the local
+// development environment.
+type localStrategy struct{}
+
+// NewLocalStrategy builds the built-in local-environment strategy, so a
+// service assembling a custom Authenticator can still include it alongside
+// strategies of its own.
+func NewLocalStrategy() AuthStrategy {
+	return localStrategy{}
+}
+
+func (localStrategy) Name() string { return "local" }
+
+func (localStrategy) Applies(request *http.Request) bool { return true }
+
+func (localStrategy) Authenticate(ctx context.Context, request *http.Request) (Principal, error) {
+	if len(os.Getenv("LOCAL_ENVIRONMENT")) > 0 {
+		return Principal{Subject: "local", Method: "local"}, nil
+	}
+
+	return Principal{}, ErrNotAuthenticated
+}
+
+// bearerStrategy authorizes requests carrying a valid opaque bearer token
+// in the Authorization header. It only applies to tokens that don't look
+// like a JWT, so it doesn't compete with a JWT-backed strategy (e.g.
+// NewOIDCStrategy) registered alongside it on the same header - see
+// looksLikeJWT.
+type bearerStrategy struct{}
+
+// NewBearerStrategy builds the built-in opaque-bearer-token strategy, so a
+// service assembling a custom Authenticator can still include it alongside
+// strategies of its own.
+func NewBearerStrategy() AuthStrategy {
+	return bearerStrategy{}
+}
+
+func (bearerStrategy) Name() string { return "bearer" }
+
+func (bearerStrategy) Applies(request *http.Request) bool {
+	token, ok := splitBearerToken(request.Header.Get("Authorization"))
+	return ok && !looksLikeJWT(token)
+}
+
+func (bearerStrategy) Authenticate(ctx context.Context, request *http.Request) (Principal, error) {
+	token, ok := splitBearerToken(request.Header.Get("Authorization"))
+	if !ok {
+		return Principal{}, errors.New("Bad Authorization header")
+	}
+
+	valid, err := bearertoken.CheckToken([]byte(token))
+	if err != nil {
+		return Principal{}, err
+	}
+
+	if valid {
+		return Principal{Subject: "bearer", Method: "bearer"}, nil
+	}
+
+	return Principal{}, ErrNotAuthenticated
+}
+
+// splitBearerToken extracts the token from a "Bearer <token>" Authorization
+// header value. ok is false if header isn't in that exact form.
+func splitBearerToken(header string) (token string, ok bool) {
+	parts := strings.Split(header, " ")
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// looksLikeJWT reports whether token has the three dot-separated segments
+// of a compact JWT (header.payload.signature). bearerStrategy and a
+// JWT-backed strategy sharing the Authorization header (e.g.
+// NewOIDCStrategy) use this so they don't both try to authenticate the
+// same token against their own backend.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// jwtStrategy authorizes requests carrying a valid JWT in a configurable
+// header, deferring to validate to check the token's signature and claims.
+// It generalizes the original WSO2-only check so any JWT issuer - WSO2,
+// or an OIDC IdP via oidcjwt - can be plugged in as a strategy.
+type jwtStrategy struct {
+	name     string
+	header   string
+	validate func(token string) (bool, error)
+
+	// claims optionally returns the verified token's claims, for
+	// strategies whose validator exposes them (oidcjwt does; wso2jwt does
+	// not). When nil, the resulting Principal carries no Claims/expiry.
+	claims func(token string) (map[string]interface{}, error)
+}
+
+func (s jwtStrategy) Name() string { return s.name }
+
+func (s jwtStrategy) Applies(request *http.Request) bool {
+	value := request.Header.Get(s.header)
+	if len(value) == 0 {
+		return false
+	}
+
+	// A JWT strategy sharing the Authorization header with bearerStrategy
+	// (e.g. NewOIDCStrategy) only applies to JWT-shaped tokens, so the two
+	// don't both try to authenticate the same opaque or JWT token against
+	// the wrong backend.
+	if s.header == "Authorization" {
+		token, ok := splitBearerToken(value)
+		return ok && looksLikeJWT(token)
+	}
+
+	return true
+}
+
+func (s jwtStrategy) Authenticate(ctx context.Context, request *http.Request) (Principal, error) {
+	token := request.Header.Get(s.header)
+
+	valid, err := s.validate(token)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	if !valid {
+		return Principal{}, ErrNotAuthenticated
+	}
+
+	principal := Principal{Subject: s.name, Method: s.name}
+
+	if s.claims != nil {
+		if claims, err := s.claims(token); err == nil {
+			principal.Claims = claims
+
+			if sub, ok := claims["sub"].(string); ok && sub != "" {
+				principal.Subject = sub
+			}
+			if iat, ok := claims["iat"].(float64); ok {
+				principal.IssuedAt = time.Unix(int64(iat), 0)
+			}
+			if exp, ok := claims["exp"].(float64); ok {
+				principal.ExpiresAt = time.Unix(int64(exp), 0)
+			}
+		}
+	}
+
+	return principal, nil
+}
+
+// NewWSO2Strategy builds the built-in WSO2-backed JWT strategy, reading the
+// token from the X-jwt-assertion header the WSO2 API Manager sets.
+func NewWSO2Strategy() AuthStrategy {
+	return jwtStrategy{
+		name:     "wso2",
+		header:   "X-jwt-assertion",
+		validate: wso2jwt.Validate,
+	}
+}